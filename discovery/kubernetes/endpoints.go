@@ -25,12 +25,129 @@ import (
 	"github.com/prometheus/common/model"
 	"github.com/prometheus/common/promslog"
 	apiv1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
 	"k8s.io/client-go/tools/cache"
 	"k8s.io/client-go/util/workqueue"
 
 	"github.com/prometheus/prometheus/discovery/targetgroup"
 )
 
+// maxEndpointsRetries is the number of times a failed sync of an Endpoints
+// key is retried with backoff before it is dropped. This mirrors the retry
+// budget used by core Kubernetes controllers (e.g. kube-controller-manager's
+// endpoint controller).
+const maxEndpointsRetries = 15
+
+// EndpointsMetrics holds the Prometheus metrics for the rate-limited
+// workqueue used by the endpoints role (depth/adds/latency/retries), by
+// role. The discovery manager constructs and registers one of these against
+// its own registerer — the same dependency-injection pattern already used
+// for the shared eventCount CounterVec passed into NewEndpoints — rather
+// than each discoverer instance registering on the global default registry,
+// which would both mislabel every role's queue under one set of counters
+// and defeat multi-registry test isolation.
+type EndpointsMetrics struct {
+	depth          *prometheus.GaugeVec
+	adds           *prometheus.CounterVec
+	latency        *prometheus.HistogramVec
+	workDuration   *prometheus.HistogramVec
+	retries        *prometheus.CounterVec
+	unfinishedWork *prometheus.GaugeVec
+	longestRunning *prometheus.GaugeVec
+}
+
+// NewEndpointsMetrics creates the Kubernetes SD workqueue metrics and
+// registers them on reg.
+func NewEndpointsMetrics(reg prometheus.Registerer) *EndpointsMetrics {
+	m := &EndpointsMetrics{
+		depth: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: "prometheus",
+			Subsystem: "sd_kubernetes",
+			Name:      "workqueue_depth",
+			Help:      "Current depth of the Kubernetes SD workqueue, by role.",
+		}, []string{"queue_name"}),
+		adds: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: "prometheus",
+			Subsystem: "sd_kubernetes",
+			Name:      "workqueue_adds_total",
+			Help:      "Total number of items added to the Kubernetes SD workqueue, by role.",
+		}, []string{"queue_name"}),
+		latency: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: "prometheus",
+			Subsystem: "sd_kubernetes",
+			Name:      "workqueue_queue_duration_seconds",
+			Help:      "How long an item stays in the Kubernetes SD workqueue before being processed, by role.",
+		}, []string{"queue_name"}),
+		workDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: "prometheus",
+			Subsystem: "sd_kubernetes",
+			Name:      "workqueue_work_duration_seconds",
+			Help:      "How long it takes to process an item from the Kubernetes SD workqueue, by role.",
+		}, []string{"queue_name"}),
+		retries: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: "prometheus",
+			Subsystem: "sd_kubernetes",
+			Name:      "workqueue_retries_total",
+			Help:      "Total number of times an item in the Kubernetes SD workqueue has been requeued after a failed sync, by role.",
+		}, []string{"queue_name"}),
+		unfinishedWork: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: "prometheus",
+			Subsystem: "sd_kubernetes",
+			Name:      "workqueue_unfinished_work_seconds",
+			Help:      "How long the currently in-flight item has been processed, by role.",
+		}, []string{"queue_name"}),
+		longestRunning: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: "prometheus",
+			Subsystem: "sd_kubernetes",
+			Name:      "workqueue_longest_running_processor_seconds",
+			Help:      "How long the longest-running item in the Kubernetes SD workqueue has been processed, by role.",
+		}, []string{"queue_name"}),
+	}
+	reg.MustRegister(m.depth, m.adds, m.latency, m.workDuration, m.retries, m.unfinishedWork, m.longestRunning)
+	return m
+}
+
+// provider adapts m to workqueue.MetricsProvider so it can be attached to a
+// single queue via workqueue.RateLimitingQueueConfig, instead of through the
+// process-global workqueue.SetProvider that would otherwise apply to every
+// role's queue.
+func (m *EndpointsMetrics) provider() workqueue.MetricsProvider {
+	return endpointsWorkqueueMetricsProvider{m}
+}
+
+type endpointsWorkqueueMetricsProvider struct {
+	m *EndpointsMetrics
+}
+
+func (p endpointsWorkqueueMetricsProvider) NewDepthMetric(name string) workqueue.GaugeMetric {
+	return p.m.depth.WithLabelValues(name)
+}
+
+func (p endpointsWorkqueueMetricsProvider) NewAddsMetric(name string) workqueue.CounterMetric {
+	return p.m.adds.WithLabelValues(name)
+}
+
+func (p endpointsWorkqueueMetricsProvider) NewLatencyMetric(name string) workqueue.HistogramMetric {
+	return p.m.latency.WithLabelValues(name)
+}
+
+func (p endpointsWorkqueueMetricsProvider) NewWorkDurationMetric(name string) workqueue.HistogramMetric {
+	return p.m.workDuration.WithLabelValues(name)
+}
+
+func (p endpointsWorkqueueMetricsProvider) NewRetriesMetric(name string) workqueue.CounterMetric {
+	return p.m.retries.WithLabelValues(name)
+}
+
+func (p endpointsWorkqueueMetricsProvider) NewUnfinishedWorkSecondsMetric(name string) workqueue.SettableGaugeMetric {
+	return p.m.unfinishedWork.WithLabelValues(name)
+}
+
+func (p endpointsWorkqueueMetricsProvider) NewLongestRunningProcessorSecondsMetric(name string) workqueue.SettableGaugeMetric {
+	return p.m.longestRunning.WithLabelValues(name)
+}
+
 // Endpoints discovers new endpoint targets.
 type Endpoints struct {
 	logger *slog.Logger
@@ -47,16 +164,88 @@ type Endpoints struct {
 	endpointsStore cache.Store
 	serviceStore   cache.Store
 
-	queue *workqueue.Type
+	queue workqueue.RateLimitingInterface
+
+	// zones and regions restrict emitted targets to the given topology
+	// values when non-empty, set from kubernetes_sd_config's "zones"/
+	// "regions" so a Prometheus instance can scrape only its local zone.
+	zones   map[string]struct{}
+	regions map[string]struct{}
+
+	// serviceSelector restricts discovery to Services whose labels it
+	// matches, set from kubernetes_sd_config's "selectors" block for
+	// role: endpoints (e.g. "prometheus.io/scrape=true"). The caller is
+	// expected to build the Service (and, transitively, Endpoints)
+	// informer with the same selector via ServiceListOptions and
+	// informers.WithTweakListOptions, so non-matching objects are excluded
+	// server-side and never reach this process's watch or cache. It is
+	// enforced again here, in sync — the single choke point every enqueue
+	// path (Endpoints, Service, Pod, Node and Namespace events all funnel
+	// through the same workqueue key) goes through — as defense-in-depth
+	// against a caller that wired the informers without ServiceListOptions,
+	// or a cache still holding objects from before a selector change.
+	serviceSelector labels.Selector
+
+	// emitEmptyServiceTargets mirrors kubernetes_sd_config's
+	// emit_empty_service_targets: when true, once the Endpoints object of a
+	// Service with a selector is removed, the group sent for it still carries
+	// the Service's meta labels, with zero targets, instead of a bare Source.
+	emitEmptyServiceTargets bool
+
+	// builders run, in registration order, against every target this
+	// discoverer produces; set from kubernetes_sd_config's "builders" list.
+	builders []TargetBuilder
+}
+
+// ServiceListOptions returns a ListOptions tweak, to be wrapped with
+// informers.WithTweakListOptions, that restricts the Service (and, via its owned Endpoints, the
+// Endpoints) informer to objects matching selector server-side, e.g.:
+//
+//	informers.NewSharedInformerFactoryWithOptions(client, resync,
+//	    informers.WithTweakListOptions(kubernetes.ServiceListOptions(selector)))
+//
+// A nil or empty selector leaves ListOptions untouched, so every Service is
+// watched, matching the "selectors" block being optional. Building the
+// informers this way is what actually cuts watch/cache memory and CPU in
+// large clusters; serviceSelector's client-side check in sync only guards
+// against a caller that didn't wire this in, or a cache lagging a selector
+// change.
+func ServiceListOptions(selector labels.Selector) func(*metav1.ListOptions) {
+	return func(options *metav1.ListOptions) {
+		if selector == nil || selector.Empty() {
+			return
+		}
+		options.LabelSelector = selector.String()
+	}
 }
 
 // NewEndpoints returns a new endpoints discovery.
 // Endpoints API is deprecated in k8s v1.33+, but we should still support it.
-func NewEndpoints(l *slog.Logger, eps cache.SharedIndexInformer, svc, pod, node, namespace cache.SharedInformer, eventCount *prometheus.CounterVec) *Endpoints {
+// zones and regions come from kubernetes_sd_config and, when non-empty,
+// restrict emitted targets to Nodes carrying a matching topology label.
+// serviceSelector, if non-nil, restricts discovery to Services whose labels
+// it matches. emitEmptyServiceTargets enables emit_empty_service_targets.
+// builders are applied, in order, to every discovered target. queueMetrics,
+// if non-nil, is used to report the workqueue's depth/adds/latency/retries;
+// it is typically shared across every role's discoverer and constructed
+// once via NewEndpointsMetrics against the caller's own registerer. With a
+// nil queueMetrics none of its series are reported for this queue; the queue
+// then reports to client-go's process-wide workqueue metrics provider (a
+// no-op unless one was set with workqueue.SetProvider).
+func NewEndpoints(l *slog.Logger, eps cache.SharedIndexInformer, svc, pod, node, namespace cache.SharedInformer, eventCount *prometheus.CounterVec, zones, regions []string, serviceSelector labels.Selector, emitEmptyServiceTargets bool, queueMetrics *EndpointsMetrics, builders ...TargetBuilder) *Endpoints {
 	if l == nil {
 		l = promslog.NewNopLogger()
 	}
 
+	if (len(zones) > 0 || len(regions) > 0) && node == nil {
+		l.Warn("role: endpoints has zones/regions configured but no Node informer was provided; every target's topology is unresolvable and will be filtered out", "zones", zones, "regions", regions)
+	}
+
+	queueConfig := workqueue.RateLimitingQueueConfig{Name: RoleEndpoint.String()}
+	if queueMetrics != nil {
+		queueConfig.MetricsProvider = queueMetrics.provider()
+	}
+
 	epAddCount := eventCount.WithLabelValues(RoleEndpoint.String(), MetricLabelRoleAdd)
 	epUpdateCount := eventCount.WithLabelValues(RoleEndpoint.String(), MetricLabelRoleUpdate)
 	epDeleteCount := eventCount.WithLabelValues(RoleEndpoint.String(), MetricLabelRoleDelete)
@@ -68,18 +257,23 @@ func NewEndpoints(l *slog.Logger, eps cache.SharedIndexInformer, svc, pod, node,
 	podUpdateCount := eventCount.WithLabelValues(RolePod.String(), MetricLabelRoleUpdate)
 
 	e := &Endpoints{
-		logger:                l,
-		endpointsInf:          eps,
-		endpointsStore:        eps.GetStore(),
-		serviceInf:            svc,
-		serviceStore:          svc.GetStore(),
-		podInf:                pod,
-		podStore:              pod.GetStore(),
-		nodeInf:               node,
-		withNodeMetadata:      node != nil,
-		namespaceInf:          namespace,
-		withNamespaceMetadata: namespace != nil,
-		queue:                 workqueue.NewNamed(RoleEndpoint.String()),
+		logger:                  l,
+		endpointsInf:            eps,
+		endpointsStore:          eps.GetStore(),
+		serviceInf:              svc,
+		serviceStore:            svc.GetStore(),
+		podInf:                  pod,
+		podStore:                pod.GetStore(),
+		nodeInf:                 node,
+		withNodeMetadata:        node != nil,
+		namespaceInf:            namespace,
+		withNamespaceMetadata:   namespace != nil,
+		queue:                   workqueue.NewRateLimitingQueueWithConfig(workqueue.DefaultControllerRateLimiter(), queueConfig),
+		zones:                   stringSet(zones),
+		regions:                 stringSet(regions),
+		serviceSelector:         serviceSelector,
+		emitEmptyServiceTargets: emitEmptyServiceTargets,
+		builders:                builders,
 	}
 
 	_, err := e.endpointsInf.AddEventHandler(cache.ResourceEventHandlerFuncs{
@@ -100,6 +294,10 @@ func NewEndpoints(l *slog.Logger, eps cache.SharedIndexInformer, svc, pod, node,
 		l.Error("Error adding endpoints event handler.", "err", err)
 	}
 
+	// serviceUpdate enqueues the owning Endpoints key when that Endpoints
+	// object is in the store; the serviceSelector, if any, is enforced once in
+	// sync rather than here, so every enqueue path (this one included) is
+	// filtered consistently.
 	serviceUpdate := func(o interface{}) {
 		svc, err := convertToService(o)
 		if err != nil {
@@ -279,28 +477,77 @@ func (e *Endpoints) process(ctx context.Context, ch chan<- []*targetgroup.Group)
 	defer e.queue.Done(keyObj)
 	key := keyObj.(string)
 
+	if err := e.sync(ctx, ch, key); err != nil {
+		if e.queue.NumRequeues(keyObj) < maxEndpointsRetries {
+			e.logger.Error("Error syncing endpoints, retrying", "key", key, "err", err, "num_requeues", e.queue.NumRequeues(keyObj))
+			e.queue.AddRateLimited(keyObj)
+			return true
+		}
+		e.logger.Error("Error syncing endpoints, giving up", "key", key, "err", err)
+	}
+	e.queue.Forget(keyObj)
+	return true
+}
+
+// sync resolves the object behind key and sends the resulting target group.
+// A non-nil error is treated as transient (e.g. a store/informer hiccup) and
+// causes process to requeue key with rate-limited backoff; malformed input
+// that retrying cannot fix is logged and swallowed instead.
+func (e *Endpoints) sync(ctx context.Context, ch chan<- []*targetgroup.Group, key string) error {
 	namespace, name, err := cache.SplitMetaNamespaceKey(key)
 	if err != nil {
 		e.logger.Error("splitting key failed", "key", key)
-		return true
+		return nil
+	}
+
+	if !e.matchesServiceSelector(namespace, name) {
+		// The Service backing this Endpoints object no longer exists, or no
+		// longer matches serviceSelector (e.g. an operator removed the
+		// scrape label). Retract any target group previously emitted for
+		// this Source so its targets stop being scraped, mirroring the
+		// !exists retraction below rather than leaving them stale forever.
+		send(ctx, ch, &targetgroup.Group{Source: endpointsSourceFromNamespaceAndName(namespace, name)})
+		return nil
 	}
 
 	o, exists, err := e.endpointsStore.GetByKey(key)
 	if err != nil {
-		e.logger.Error("getting object from store failed", "key", key)
-		return true
+		return fmt.Errorf("getting object from store failed: %w", err)
 	}
 	if !exists {
-		send(ctx, ch, &targetgroup.Group{Source: endpointsSourceFromNamespaceAndName(namespace, name)})
-		return true
+		send(ctx, ch, e.emptyServiceGroup(namespace, name))
+		return nil
 	}
 	eps, err := convertToEndpoints(o)
 	if err != nil {
 		e.logger.Error("converting to Endpoints object failed", "err", err)
-		return true
+		return nil
 	}
 	send(ctx, ch, e.buildEndpoints(eps))
-	return true
+	return nil
+}
+
+// matchesServiceSelector reports whether the Service named namespace/name
+// passes e's serviceSelector. It fails closed: an unset selector always
+// matches, but a configured selector excludes a Service that can't be
+// resolved, since "scope discovery to this selector" should never leak
+// targets for a Service we couldn't confirm matches it.
+func (e *Endpoints) matchesServiceSelector(namespace, name string) bool {
+	if e.serviceSelector == nil {
+		return true
+	}
+
+	obj, exists, err := e.serviceStore.GetByKey(namespacedName(namespace, name))
+	if err != nil {
+		e.logger.Error("retrieving service failed", "err", err)
+		return false
+	}
+	if !exists {
+		return false
+	}
+
+	svc := obj.(*apiv1.Service)
+	return e.serviceSelector.Matches(labels.Set(svc.Labels))
 }
 
 func convertToEndpoints(o interface{}) (*apiv1.Endpoints, error) {
@@ -328,8 +575,29 @@ const (
 	endpointPortProtocolLabel      = metaLabelPrefix + "endpoint_port_protocol"
 	endpointAddressTargetKindLabel = metaLabelPrefix + "endpoint_address_target_kind"
 	endpointAddressTargetNameLabel = metaLabelPrefix + "endpoint_address_target_name"
+	endpointZoneLabel              = metaLabelPrefix + "endpoint_zone"
+	endpointRegionLabel            = metaLabelPrefix + "endpoint_region"
+
+	// nodeZoneLabel and nodeRegionLabel are the stable topology labels set by
+	// the kubelet/cloud-provider on Node objects. The "failure-domain.beta"
+	// variants are deprecated since Kubernetes 1.17 but still populated on
+	// older clusters, so both are consulted.
+	nodeZoneLabel             = "topology.kubernetes.io/zone"
+	nodeRegionLabel           = "topology.kubernetes.io/region"
+	nodeZoneLabelDeprecated   = "failure-domain.beta.kubernetes.io/zone"
+	nodeRegionLabelDeprecated = "failure-domain.beta.kubernetes.io/region"
 )
 
+// buildEndpoints always attaches namespace and service meta labels, even
+// when eps.Subsets is empty (e.g. a selector Service with zero matching
+// Pods still has an Endpoints object, just with no addresses) — so that
+// "down service, zero ready endpoints" case already keeps its full service
+// metadata for relabeling/dead-man alerts with no targets, without needing
+// emit_empty_service_targets. That flag only extends the same guarantee to
+// the rarer case where the Endpoints object itself no longer exists (see
+// emptyServiceGroup), which buildEndpoints never sees. A Service whose
+// Endpoints object has never existed is not enqueued, so no group is sent
+// for it.
 func (e *Endpoints) buildEndpoints(eps *apiv1.Endpoints) *targetgroup.Group {
 	tg := &targetgroup.Group{
 		Source: endpointsSource(eps),
@@ -337,7 +605,7 @@ func (e *Endpoints) buildEndpoints(eps *apiv1.Endpoints) *targetgroup.Group {
 	tg.Labels = model.LabelSet{
 		namespaceLabel: lv(eps.Namespace),
 	}
-	e.addServiceLabels(eps.Namespace, eps.Name, tg)
+	svc := e.addServiceLabels(eps.Namespace, eps.Name, tg)
 	// Add endpoints labels metadata.
 	addObjectMetaLabels(tg.Labels, eps.ObjectMeta, RoleEndpoint)
 
@@ -373,14 +641,32 @@ func (e *Endpoints) buildEndpoints(eps *apiv1.Endpoints) *targetgroup.Group {
 			target[model.LabelName(endpointHostname)] = lv(addr.Hostname)
 		}
 
-		if e.withNodeMetadata {
-			if addr.NodeName != nil {
-				target = addNodeLabels(target, e.nodeInf, e.logger, addr.NodeName)
-			} else if addr.TargetRef != nil && addr.TargetRef.Kind == "Node" {
-				target = addNodeLabels(target, e.nodeInf, e.logger, &addr.TargetRef.Name)
+		// Resolving topology requires a Node informer regardless of whether
+		// withNodeMetadata is set: zones/regions filtering must not silently
+		// let everything through just because node metadata labels weren't
+		// requested. When no Node informer is available at all, zone/region
+		// are left empty and, if a filter is configured, matchesTopologyFilters
+		// drops the target below rather than leaking it unfiltered.
+		var zone, region string
+		nodeName := endpointNodeNameFor(addr)
+		if nodeName != nil && e.nodeInf != nil {
+			zone, region = nodeTopology(e.nodeInf, e.logger, *nodeName)
+		}
+
+		if e.withNodeMetadata && nodeName != nil {
+			target = addNodeLabels(target, e.nodeInf, e.logger, nodeName)
+			if zone != "" {
+				target[model.LabelName(endpointZoneLabel)] = lv(zone)
+			}
+			if region != "" {
+				target[model.LabelName(endpointRegionLabel)] = lv(region)
 			}
 		}
 
+		if !e.matchesTopologyFilters(zone, region) {
+			return
+		}
+
 		pod := e.resolvePodRef(addr.TargetRef)
 		if pod == nil {
 			// This target is not a Pod, so don't continue with Pod specific logic.
@@ -412,6 +698,7 @@ func (e *Endpoints) buildEndpoints(eps *apiv1.Endpoints) *targetgroup.Group {
 					target[podContainerPortNumberLabel] = lv(ports)
 					target[podContainerPortProtocolLabel] = lv(string(port.Protocol))
 					target[podContainerIsInit] = lv(strconv.FormatBool(isInit))
+					e.runPodContainerPortBuilders(pod, c, cport, target)
 					break
 				}
 			}
@@ -480,14 +767,195 @@ func (e *Endpoints) buildEndpoints(eps *apiv1.Endpoints) *targetgroup.Group {
 					podContainerPortProtocolLabel: lv(string(cport.Protocol)),
 					podContainerIsInit:            lv(strconv.FormatBool(isInit)),
 				}
-				tg.Targets = append(tg.Targets, target.Merge(podLabels(pe.pod)))
+				target = target.Merge(podLabels(pe.pod))
+				e.runPodContainerPortBuilders(pe.pod, c, cport, target)
+				tg.Targets = append(tg.Targets, target)
 			}
 		}
 	}
 
+	e.runEndpointsBuilders(eps, svc, tg)
 	return tg
 }
 
+// endpointNodeNameFor returns the name of the Node backing addr, either
+// because the address was explicitly assigned to it or because it directly
+// targets a Node object, or nil if addr isn't associated with a Node.
+func endpointNodeNameFor(addr apiv1.EndpointAddress) *string {
+	if addr.NodeName != nil {
+		return addr.NodeName
+	}
+	if addr.TargetRef != nil && addr.TargetRef.Kind == "Node" {
+		return &addr.TargetRef.Name
+	}
+	return nil
+}
+
+// nodeTopology returns the zone and region of nodeName, falling back to the
+// deprecated failure-domain labels when the stable topology.kubernetes.io
+// ones aren't set. Either value is empty if unknown.
+func nodeTopology(nodeInf cache.SharedInformer, logger *slog.Logger, nodeName string) (zone, region string) {
+	obj, exists, err := nodeInf.GetStore().GetByKey(nodeName)
+	if err != nil {
+		logger.Error("Error getting node", "node", nodeName, "err", err)
+		return "", ""
+	}
+	if !exists {
+		return "", ""
+	}
+
+	node := obj.(*apiv1.Node)
+	zone = node.Labels[nodeZoneLabel]
+	if zone == "" {
+		zone = node.Labels[nodeZoneLabelDeprecated]
+	}
+	region = node.Labels[nodeRegionLabel]
+	if region == "" {
+		region = node.Labels[nodeRegionLabelDeprecated]
+	}
+	return zone, region
+}
+
+// matchesTopologyFilters reports whether zone/region pass the zones/regions
+// restrictions configured on e. An empty filter set matches everything.
+func (e *Endpoints) matchesTopologyFilters(zone, region string) bool {
+	if len(e.zones) > 0 {
+		if _, ok := e.zones[zone]; !ok {
+			return false
+		}
+	}
+	if len(e.regions) > 0 {
+		if _, ok := e.regions[region]; !ok {
+			return false
+		}
+	}
+	return true
+}
+
+// stringSet converts values into a lookup set, or nil if values is empty.
+func stringSet(values []string) map[string]struct{} {
+	if len(values) == 0 {
+		return nil
+	}
+	set := make(map[string]struct{}, len(values))
+	for _, v := range values {
+		set[v] = struct{}{}
+	}
+	return set
+}
+
+// TargetBuilder lets operators inject extra meta labels into targets
+// discovered by the endpoints role without forking Prometheus. Builders are
+// resolved from kubernetes_sd_config's "builders" list via
+// ResolveTargetBuilders and run, in order, after the built-in
+// endpoint/pod/node/service labels have already been attached; an empty
+// "builders" list, or a list containing only DefaultTargetBuilderName,
+// reproduces the built-in behavior exactly, since that behavior lives in
+// buildEndpoints itself rather than in a builder.
+type TargetBuilder interface {
+	// OnEndpoints is called once per discovered Endpoints object, after its
+	// target group has been fully built by the default logic, and may
+	// mutate tg in place — e.g. to add or amend labels on tg.Targets from a
+	// lookup keyed by namespace/name rather than any single target, such as
+	// a CRD or external CMDB lookup.
+	OnEndpoints(eps *apiv1.Endpoints, svc *apiv1.Service, tg *targetgroup.Group)
+
+	// OnPodContainerPort is called once for every Pod container port that
+	// backs a target, both ports matched to a service endpoint and "extra"
+	// container ports discovered directly via the Pod, letting builders add
+	// per-container metadata (e.g. parsed annotations, resource requests)
+	// to that specific target.
+	OnPodContainerPort(pod *apiv1.Pod, container apiv1.Container, port apiv1.ContainerPort, target model.LabelSet)
+}
+
+// DefaultTargetBuilderName is the "default" entry accepted in
+// kubernetes_sd_config's "builders" list; it resolves to DefaultTargetBuilder.
+const DefaultTargetBuilderName = "default"
+
+// DefaultTargetBuilder is a no-op TargetBuilder. The base label set is
+// produced directly by buildEndpoints rather than by a builder, so
+// registering only DefaultTargetBuilder (or an empty "builders" list, which
+// ResolveTargetBuilders treats the same way) reproduces the built-in
+// behavior byte-for-byte; naming it explicitly lets it be listed alongside
+// other builders to control ordering, e.g. "builders: [my-plugin, default]".
+type DefaultTargetBuilder struct{}
+
+func (DefaultTargetBuilder) OnEndpoints(*apiv1.Endpoints, *apiv1.Service, *targetgroup.Group) {}
+
+func (DefaultTargetBuilder) OnPodContainerPort(*apiv1.Pod, apiv1.Container, apiv1.ContainerPort, model.LabelSet) {
+}
+
+// runEndpointsBuilders runs every builder registered on e against tg.
+func (e *Endpoints) runEndpointsBuilders(eps *apiv1.Endpoints, svc *apiv1.Service, tg *targetgroup.Group) {
+	for _, b := range e.builders {
+		b.OnEndpoints(eps, svc, tg)
+	}
+}
+
+// runPodContainerPortBuilders runs every builder registered on e against a
+// single matched container port's target.
+func (e *Endpoints) runPodContainerPortBuilders(pod *apiv1.Pod, container apiv1.Container, port apiv1.ContainerPort, target model.LabelSet) {
+	for _, b := range e.builders {
+		b.OnPodContainerPort(pod, container, port, target)
+	}
+}
+
+// TargetBuilderFactories lets kubernetes_sd_config's "builders" list resolve
+// builder names to constructors. Out-of-tree builders register themselves
+// here (e.g. from an init function in their own package) before discovery
+// managers are constructed.
+var TargetBuilderFactories = map[string]func() TargetBuilder{
+	DefaultTargetBuilderName: func() TargetBuilder { return DefaultTargetBuilder{} },
+	"container-resources":    func() TargetBuilder { return ContainerResourcesTargetBuilder{} },
+}
+
+// ResolveTargetBuilders resolves kubernetes_sd_config's "builders" names, in
+// order, into TargetBuilder instances via TargetBuilderFactories. An unknown
+// name is an error so a config typo fails fast at load time instead of
+// silently discovering fewer labels.
+func ResolveTargetBuilders(names []string) ([]TargetBuilder, error) {
+	builders := make([]TargetBuilder, 0, len(names))
+	for _, name := range names {
+		factory, ok := TargetBuilderFactories[name]
+		if !ok {
+			return nil, fmt.Errorf("unknown kubernetes_sd_config target builder %q", name)
+		}
+		builders = append(builders, factory())
+	}
+	return builders, nil
+}
+
+const (
+	podContainerCPURequestLabel    = metaLabelPrefix + "pod_container_resource_request_cpu"
+	podContainerMemoryRequestLabel = metaLabelPrefix + "pod_container_resource_request_memory"
+	podContainerCPULimitLabel      = metaLabelPrefix + "pod_container_resource_limit_cpu"
+	podContainerMemoryLimitLabel   = metaLabelPrefix + "pod_container_resource_limit_memory"
+)
+
+// ContainerResourcesTargetBuilder is a TargetBuilder that adds a container's
+// CPU/memory requests and limits as meta labels, a frequently requested
+// capability that previously required patching this file. Register it via
+// kubernetes_sd_config's "builders: [container-resources]".
+type ContainerResourcesTargetBuilder struct{}
+
+func (ContainerResourcesTargetBuilder) OnEndpoints(*apiv1.Endpoints, *apiv1.Service, *targetgroup.Group) {
+}
+
+func (ContainerResourcesTargetBuilder) OnPodContainerPort(_ *apiv1.Pod, container apiv1.Container, _ apiv1.ContainerPort, target model.LabelSet) {
+	if q, ok := container.Resources.Requests[apiv1.ResourceCPU]; ok {
+		target[podContainerCPURequestLabel] = lv(q.String())
+	}
+	if q, ok := container.Resources.Requests[apiv1.ResourceMemory]; ok {
+		target[podContainerMemoryRequestLabel] = lv(q.String())
+	}
+	if q, ok := container.Resources.Limits[apiv1.ResourceCPU]; ok {
+		target[podContainerCPULimitLabel] = lv(q.String())
+	}
+	if q, ok := container.Resources.Limits[apiv1.ResourceMemory]; ok {
+		target[podContainerMemoryLimitLabel] = lv(q.String())
+	}
+}
+
 func (e *Endpoints) resolvePodRef(ref *apiv1.ObjectReference) *apiv1.Pod {
 	if ref == nil || ref.Kind != "Pod" {
 		return nil
@@ -504,18 +972,60 @@ func (e *Endpoints) resolvePodRef(ref *apiv1.ObjectReference) *apiv1.Pod {
 	return obj.(*apiv1.Pod)
 }
 
-func (e *Endpoints) addServiceLabels(ns, name string, tg *targetgroup.Group) {
+// addServiceLabels merges the meta labels of the Service named ns/name into
+// tg and returns that Service, or nil if it doesn't exist.
+func (e *Endpoints) addServiceLabels(ns, name string, tg *targetgroup.Group) *apiv1.Service {
 	obj, exists, err := e.serviceStore.GetByKey(namespacedName(ns, name))
 	if err != nil {
 		e.logger.Error("retrieving service failed", "err", err)
-		return
+		return nil
 	}
 	if !exists {
-		return
+		return nil
+	}
+	svc := obj.(*apiv1.Service)
+
+	tg.Labels = tg.Labels.Merge(serviceLabels(svc))
+	return svc
+}
+
+// emptyServiceGroup returns the target group sent when no Endpoints object
+// exists for namespace/name, e.g. because it was just deleted. By default it
+// only carries Source, so relabeling rules and dead-man alerts lose every
+// service-level meta label the moment a service has no endpoints. With
+// emit_empty_service_targets enabled, a Service with a selector still gets
+// its full meta labels attached — namespace label, namespace metadata (if
+// withNamespaceMetadata) and service meta labels, mirroring what
+// buildEndpoints attaches for the equivalent "Endpoints object exists but
+// Subsets is empty" case — so those rules keep something stable to match
+// against.
+func (e *Endpoints) emptyServiceGroup(namespace, name string) *targetgroup.Group {
+	tg := &targetgroup.Group{Source: endpointsSourceFromNamespaceAndName(namespace, name)}
+	if !e.emitEmptyServiceTargets {
+		return tg
+	}
+
+	obj, exists, err := e.serviceStore.GetByKey(namespacedName(namespace, name))
+	if err != nil {
+		e.logger.Error("retrieving service failed", "err", err)
+		return tg
+	}
+	if !exists {
+		return tg
 	}
 	svc := obj.(*apiv1.Service)
+	if svc.Spec.Selector == nil {
+		// Without a selector, Kubernetes never creates an Endpoints object
+		// for this Service, so there's nothing to synthesize.
+		return tg
+	}
 
+	tg.Labels = model.LabelSet{namespaceLabel: lv(namespace)}
 	tg.Labels = tg.Labels.Merge(serviceLabels(svc))
+	if e.withNamespaceMetadata {
+		tg.Labels = addNamespaceLabels(tg.Labels, e.namespaceInf, e.logger, namespace)
+	}
+	return tg
 }
 
 func addNodeLabels(tg model.LabelSet, nodeInf cache.SharedInformer, logger *slog.Logger, nodeName *string) model.LabelSet {
@@ -0,0 +1,87 @@
+// Copyright 2016 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package kubernetes
+
+import (
+	"testing"
+
+	"github.com/prometheus/common/model"
+	"github.com/stretchr/testify/require"
+	apiv1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+)
+
+func TestServiceListOptions(t *testing.T) {
+	options := &metav1.ListOptions{}
+	ServiceListOptions(nil)(options)
+	require.Empty(t, options.LabelSelector)
+
+	selector, err := labels.Parse("prometheus.io/scrape=true")
+	require.NoError(t, err)
+	ServiceListOptions(selector)(options)
+	require.Equal(t, "prometheus.io/scrape=true", options.LabelSelector)
+}
+
+func TestResolveTargetBuilders(t *testing.T) {
+	builders, err := ResolveTargetBuilders([]string{"container-resources", DefaultTargetBuilderName})
+	require.NoError(t, err)
+	require.Len(t, builders, 2)
+	require.IsType(t, ContainerResourcesTargetBuilder{}, builders[0])
+	require.IsType(t, DefaultTargetBuilder{}, builders[1])
+
+	builders, err = ResolveTargetBuilders(nil)
+	require.NoError(t, err)
+	require.Empty(t, builders)
+
+	_, err = ResolveTargetBuilders([]string{"does-not-exist"})
+	require.Error(t, err)
+}
+
+func TestContainerResourcesTargetBuilder(t *testing.T) {
+	container := apiv1.Container{
+		Name: "app",
+		Resources: apiv1.ResourceRequirements{
+			Requests: apiv1.ResourceList{
+				apiv1.ResourceCPU:    resource.MustParse("100m"),
+				apiv1.ResourceMemory: resource.MustParse("64Mi"),
+			},
+			Limits: apiv1.ResourceList{
+				apiv1.ResourceCPU: resource.MustParse("500m"),
+			},
+		},
+	}
+
+	target := model.LabelSet{}
+	ContainerResourcesTargetBuilder{}.OnPodContainerPort(nil, container, apiv1.ContainerPort{}, target)
+
+	require.Equal(t, model.LabelValue("100m"), target[podContainerCPURequestLabel])
+	require.Equal(t, model.LabelValue("64Mi"), target[podContainerMemoryRequestLabel])
+	require.Equal(t, model.LabelValue("500m"), target[podContainerCPULimitLabel])
+	require.NotContains(t, target, model.LabelName(podContainerMemoryLimitLabel))
+}
+
+func TestContainerResourcesTargetBuilderNoResources(t *testing.T) {
+	target := model.LabelSet{}
+	ContainerResourcesTargetBuilder{}.OnPodContainerPort(nil, apiv1.Container{Name: "app"}, apiv1.ContainerPort{}, target)
+	require.Empty(t, target)
+}
+
+func TestDefaultTargetBuilderIsNoOp(t *testing.T) {
+	target := model.LabelSet{"__meta_kubernetes_pod_name": "keep-me"}
+	DefaultTargetBuilder{}.OnPodContainerPort(nil, apiv1.Container{}, apiv1.ContainerPort{}, target)
+	DefaultTargetBuilder{}.OnEndpoints(nil, nil, nil)
+	require.Equal(t, model.LabelSet{"__meta_kubernetes_pod_name": "keep-me"}, target)
+}